@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Host is a single monitored address within a HostGroup, with its own
+// check parameters.
+type Host struct {
+	Name      string
+	Address   string
+	Timeout   time.Duration
+	Threshold int
+}
+
+// HostGroup is a named collection of hosts sharing a probe interval.
+type HostGroup struct {
+	Name     string
+	Interval time.Duration
+	Hosts    []Host
+}
+
+// Config is the parsed form of a --config file.
+type Config struct {
+	Interval time.Duration
+	Groups   []HostGroup
+}
+
+const (
+	defaultInterval  = 30 * time.Second
+	defaultTimeout   = 1900 * time.Millisecond
+	defaultThreshold = 1
+)
+
+// loadConfig reads and parses the config file at path.
+func loadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config %s: %v", path, err)
+	}
+	defer f.Close()
+	return parseConfig(f)
+}
+
+// parseConfig parses the simple line-oriented config grammar:
+//
+//	set interval 30
+//	monitor group webservers
+//	  host www1 address 10.0.0.1
+//	    check ping timeout 2s count 3
+//
+// Indentation is cosmetic and ignored. '#' starts a comment, whether on its
+// own line or trailing other content.
+func parseConfig(r io.Reader) (*Config, error) {
+	cfg := &Config{Interval: defaultInterval}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	var group *HostGroup
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(stripComment(scanner.Text()))
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "set":
+			if len(fields) != 3 || fields[1] != "interval" {
+				return nil, fmt.Errorf("config line %d: malformed 'set' directive", lineNo)
+			}
+			secs, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("config line %d: invalid interval %q: %v", lineNo, fields[2], err)
+			}
+			cfg.Interval = time.Duration(secs) * time.Second
+		case "monitor":
+			if len(fields) != 3 || fields[1] != "group" {
+				return nil, fmt.Errorf("config line %d: malformed 'monitor' directive", lineNo)
+			}
+			cfg.Groups = append(cfg.Groups, HostGroup{Name: fields[2], Interval: cfg.Interval})
+			group = &cfg.Groups[len(cfg.Groups)-1]
+		case "host":
+			if group == nil {
+				return nil, fmt.Errorf("config line %d: 'host' outside of a 'monitor group' block", lineNo)
+			}
+			if len(fields) != 4 || fields[2] != "address" {
+				return nil, fmt.Errorf("config line %d: malformed 'host' directive", lineNo)
+			}
+			group.Hosts = append(group.Hosts, Host{
+				Name:      fields[1],
+				Address:   fields[3],
+				Timeout:   defaultTimeout,
+				Threshold: defaultThreshold,
+			})
+		case "check":
+			if group == nil || len(group.Hosts) == 0 {
+				return nil, fmt.Errorf("config line %d: 'check' outside of a 'host' block", lineNo)
+			}
+			if len(fields) != 6 || fields[1] != "ping" || fields[2] != "timeout" || fields[4] != "count" {
+				return nil, fmt.Errorf("config line %d: malformed 'check' directive", lineNo)
+			}
+			timeout, err := time.ParseDuration(fields[3])
+			if err != nil {
+				return nil, fmt.Errorf("config line %d: invalid timeout %q: %v", lineNo, fields[3], err)
+			}
+			count, err := strconv.Atoi(fields[5])
+			if err != nil {
+				return nil, fmt.Errorf("config line %d: invalid count %q: %v", lineNo, fields[5], err)
+			}
+			host := &group.Hosts[len(group.Hosts)-1]
+			host.Timeout = timeout
+			host.Threshold = count
+		default:
+			return nil, fmt.Errorf("config line %d: unknown directive %q", lineNo, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func stripComment(line string) string {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	return line
+}
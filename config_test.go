@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	const cfg = `
+# a comment on its own line
+set interval 30
+monitor group webservers
+  host www1 address 10.0.0.1 # trailing comment
+    check ping timeout 2s count 3
+  host www2 address 10.0.0.2
+monitor group routers
+  host r1 address 10.0.1.1
+`
+	c, err := parseConfig(strings.NewReader(cfg))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	if c.Interval != 30*time.Second {
+		t.Fatalf("Interval = %s, want 30s", c.Interval)
+	}
+	if len(c.Groups) != 2 {
+		t.Fatalf("len(Groups) = %d, want 2", len(c.Groups))
+	}
+
+	web := c.Groups[0]
+	if web.Name != "webservers" || len(web.Hosts) != 2 {
+		t.Fatalf("unexpected webservers group: %+v", web)
+	}
+	if h := web.Hosts[0]; h.Name != "www1" || h.Address != "10.0.0.1" || h.Timeout != 2*time.Second || h.Threshold != 3 {
+		t.Fatalf("unexpected host: %+v", h)
+	}
+	if h := web.Hosts[1]; h.Timeout != defaultTimeout || h.Threshold != defaultThreshold {
+		t.Fatalf("host without a 'check' directive should keep the defaults: %+v", h)
+	}
+
+	routers := c.Groups[1]
+	if routers.Name != "routers" || len(routers.Hosts) != 1 {
+		t.Fatalf("unexpected routers group: %+v", routers)
+	}
+}
+
+func TestParseConfigRejectsHostOutsideGroup(t *testing.T) {
+	const cfg = "host www1 address 10.0.0.1\n"
+	if _, err := parseConfig(strings.NewReader(cfg)); err == nil {
+		t.Fatal("expected an error for a 'host' directive outside of a 'monitor group' block")
+	}
+}
+
+func TestParseConfigRejectsUnknownDirective(t *testing.T) {
+	const cfg = "bogus directive\n"
+	if _, err := parseConfig(strings.NewReader(cfg)); err == nil {
+		t.Fatal("expected an error for an unknown directive")
+	}
+}
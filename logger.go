@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Logger is the injectable sink for diagnostic messages and per-probe
+// events. Swapping implementations changes the on-disk format without
+// touching any of the calling code.
+type Logger interface {
+	Warnf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	// Event records a single probe outcome for group/host/target, e.g.
+	// "outage_detected" or "outage_cleared".
+	Event(group, host, target, event string, seq int, rtt time.Duration)
+}
+
+// textLogger renders the original fixed-width pipe-delimited format.
+type textLogger struct {
+	w io.Writer
+}
+
+func newTextLogger(w io.Writer) Logger {
+	return &textLogger{w: w}
+}
+
+func (l *textLogger) Warnf(format string, args ...interface{}) {
+	l.write(fmt.Sprintf(format, args...))
+}
+
+func (l *textLogger) Infof(format string, args ...interface{}) {
+	l.write(fmt.Sprintf(format, args...))
+}
+
+func (l *textLogger) Event(group, host, target, event string, seq int, rtt time.Duration) {
+	l.write(fmt.Sprintf("group: %s host: %s (%s): %s seq=%d rtt=%s", group, host, target, event, seq, rtt))
+}
+
+func (l *textLogger) write(msg string) {
+	r := fmt.Sprintf("| %-80s| %-26s|\n", msg, timeStamp())
+	if _, err := io.WriteString(l.w, r); err != nil {
+		log.Fatalf("Failed to record event into the log: %v\n", err)
+	}
+	if f, ok := l.w.(*os.File); ok {
+		f.Sync()
+	}
+}
+
+// jsonLogger renders one JSON object per line:
+// {ts, level, group, host, ip, event, rtt_ms, seq}.
+type jsonLogger struct {
+	w io.Writer
+}
+
+func newJSONLogger(w io.Writer) Logger {
+	return &jsonLogger{w: w}
+}
+
+type jsonEvent struct {
+	Ts    string  `json:"ts"`
+	Level string  `json:"level"`
+	Group string  `json:"group,omitempty"`
+	Host  string  `json:"host,omitempty"`
+	IP    string  `json:"ip,omitempty"`
+	Event string  `json:"event"`
+	RTTMs float64 `json:"rtt_ms,omitempty"`
+	Seq   int     `json:"seq,omitempty"`
+}
+
+func (l *jsonLogger) Warnf(format string, args ...interface{}) {
+	l.write(jsonEvent{Level: "warn", Event: fmt.Sprintf(format, args...)})
+}
+
+func (l *jsonLogger) Infof(format string, args ...interface{}) {
+	l.write(jsonEvent{Level: "info", Event: fmt.Sprintf(format, args...)})
+}
+
+func (l *jsonLogger) Event(group, host, target, event string, seq int, rtt time.Duration) {
+	l.write(jsonEvent{
+		Level: "info",
+		Group: group,
+		Host:  host,
+		IP:    target,
+		Event: event,
+		RTTMs: float64(rtt.Microseconds()) / 1000.0,
+		Seq:   seq,
+	})
+}
+
+func (l *jsonLogger) write(e jsonEvent) {
+	e.Ts = timeStamp()
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+	l.w.Write(b)
+}
@@ -1,60 +1,70 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/sbezverk/bg-ping/pinger"
+
 	"golang.org/x/net/icmp"
-	"golang.org/x/net/ipv4"
 )
 
-type pingPacket struct {
-	ID  int
-	Seq int
+// family identifies which IP version a target/socket belongs to.
+type family int
+
+const (
+	v4 family = 4
+	v6 family = 6
+
+	// ICMP protocol numbers, used when parsing inbound packets.
+	protoICMP   = 1
+	protoICMPv6 = 58
+)
+
+// pClientKey identifies a pinger across both the v4 and v6 sockets, since
+// ICMP IDs are only guaranteed unique within a single address family.
+type pClientKey struct {
+	Family family
+	ID     int
 }
 
-type pClient struct {
-	control chan pingPacket
-	ID      int
-	ip      string
-	outage  bool
+// clientEntry pairs a running Pinger with the monitoredTarget metadata
+// needed to log outages with group/host context.
+type clientEntry struct {
+	pinger *pinger.Pinger
+	target monitoredTarget
 }
 
-var wg sync.WaitGroup
+// monitoredTarget is a single host to probe, with the group/host naming and
+// check parameters used for logging, regardless of whether it came from
+// --ip or --config.
+type monitoredTarget struct {
+	GroupName string
+	HostName  string
+	Address   string
+	Interval  time.Duration
+	Timeout   time.Duration
+	Threshold int
+}
 
-const programVersion = "0.2.1"
+const programVersion = "0.8.0"
 
 func usage() string {
-	return fmt.Sprintf("\nUsage:\n%s \t--ip Comma separated list of IPs to monitor, ex: --ip X.X.X.X,Y.Y.Y.Y \n\t\t[--log folder where to create the log file. Default: /var/log/ ]\n\n", os.Args[0])
+	return fmt.Sprintf("\nUsage:\n%s \t--ip Comma separated list of IPv4/IPv6 addresses to monitor, ex: --ip X.X.X.X,Y.Y.Y.Y,::1 \n\t\t--config Path to a config file defining monitored host groups (see parseConfig in config.go for the directive grammar) \n\t\t[--log folder where to create the log file. Default: /var/log/ ]\n\n", os.Args[0])
 
 }
 
-func isValidIPv4(ip string) bool {
-	parts := strings.Split(ip, ".")
-	if len(parts) != 4 {
-		return false
-	}
-	for _, x := range parts {
-		if i, err := strconv.Atoi(x); err == nil {
-			if i < 0 || i > 255 {
-				return false
-			}
-		} else {
-			return false
-		}
-	}
-	return true
-}
-
 func timeStamp() string {
 	t := time.Now()
 	return fmt.Sprintf("%d-%02d-%02dT%02d:%02d:%02d_%04d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/1000000)
@@ -70,98 +80,234 @@ func startLogging(logLocation string) *os.File {
 	return logFile
 }
 
-func parseIPs(listIPs string) ([]string, error) {
-	var pingIPs []string
-	ips := strings.Split(listIPs, ",")
-	for _, ip := range ips {
-		if !isValidIPv4(ip) {
-			return nil, fmt.Errorf(" %s is an invalid ip address", ip)
+// parseIPs splits the comma separated --ip list into monitoredTargets. Each
+// entry may be a single address, a CIDR block, or a dashed range; the
+// latter two are expanded to their individual host addresses, capped
+// overall at maxTargets. truncated reports whether that cap was hit.
+func parseIPs(listIPs string, maxTargets int) (targets []monitoredTarget, truncated bool, err error) {
+	for _, entry := range strings.Split(listIPs, ",") {
+		entry = strings.TrimSpace(entry)
+		addrs, hitCap, err := expandEntry(entry, maxTargets-len(targets))
+		if err != nil {
+			return nil, false, err
+		}
+		for _, a := range addrs {
+			targets = append(targets, monitoredTarget{
+				GroupName: entry,
+				HostName:  a,
+				Address:   a,
+				Interval:  900 * time.Millisecond,
+				Timeout:   1900 * time.Millisecond,
+				Threshold: 1,
+			})
+		}
+		if hitCap {
+			truncated = true
+			break
 		}
-		pingIPs = append(pingIPs, ip)
 	}
-	return pingIPs, nil
+	return targets, truncated, nil
 }
 
-func recordEvent(msg string, logFile *os.File) {
-	r := fmt.Sprintf("| %-80s| %-26s|\n", msg, timeStamp())
-	if _, err := logFile.WriteString(r); err != nil {
-		log.Fatalf("Failed to record event into the log: %v\n", err)
-		os.Exit(1)
+// targetsFromConfig flattens a parsed Config into monitoredTargets.
+func targetsFromConfig(cfg *Config) []monitoredTarget {
+	var targets []monitoredTarget
+	for _, g := range cfg.Groups {
+		for _, h := range g.Hosts {
+			targets = append(targets, monitoredTarget{
+				GroupName: g.Name,
+				HostName:  h.Name,
+				Address:   h.Address,
+				Interval:  g.Interval,
+				Timeout:   h.Timeout,
+				Threshold: h.Threshold,
+			})
+		}
 	}
-	logFile.Sync()
+	return targets
 }
 
-func pingServer(c *icmp.PacketConn, clients map[int]pClient, logFile *os.File) {
+// newPinger builds a Pinger for t and registers it in pingers, rerolling
+// its ID on collision. Collisions are rare for small target lists but
+// become likely once a CIDR sweep hands out thousands of IDs drawn from the
+// same 16-bit space per address family.
+func newPinger(t monitoredTarget, fam family, conn *icmp.PacketConn, pFam pinger.Family, mode pinger.Mode, pingers map[pClientKey]*pinger.Pinger) *pinger.Pinger {
+	p := pinger.New(conn, t.Address, pFam)
+	p.Mode = mode
+	p.Interval = t.Interval
+	p.Timeout = t.Timeout
+	key := pClientKey{Family: fam, ID: int(p.ID)}
+	for {
+		if _, exists := pingers[key]; !exists {
+			break
+		}
+		p.ID = pinger.RandomID()
+		key = pClientKey{Family: fam, ID: int(p.ID)}
+	}
+	pingers[key] = p
+	return p
+}
+
+func familyOf(ip string) family {
+	if net.ParseIP(ip).To4() != nil {
+		return v4
+	}
+	return v6
+}
+
+// listenICMP opens the ICMP socket for fam. When forceUnprivileged is set
+// it always opens a SOCK_DGRAM ("udp4"/"udp6") socket, which does not
+// require root / CAP_NET_RAW but relies on the kernel's
+// net.ipv4.ping_group_range sysctl to permit this process's group. When
+// not set it first tries a raw socket and transparently falls back to the
+// unprivileged mode on a permission error.
+func listenICMP(fam family, forceUnprivileged bool, l Logger) (*icmp.PacketConn, pinger.Mode, error) {
+	rawNetwork, udpNetwork, addr := "ip4:icmp", "udp4", "0.0.0.0"
+	if fam == v6 {
+		rawNetwork, udpNetwork, addr = "ip6:ipv6-icmp", "udp6", "::"
+	}
+
+	if forceUnprivileged {
+		l.Warnf("Using unprivileged ICMP socket (%s); this requires net.ipv4.ping_group_range to include this process's group", udpNetwork)
+		c, err := icmp.ListenPacket(udpNetwork, addr)
+		return c, pinger.ModeUnprivileged, err
+	}
+
+	c, err := icmp.ListenPacket(rawNetwork, addr)
+	if err != nil && errors.Is(err, os.ErrPermission) {
+		l.Warnf("Raw ICMP socket unavailable (%v), falling back to unprivileged socket (%s); this requires net.ipv4.ping_group_range to include this process's group", err, udpNetwork)
+		c, err = icmp.ListenPacket(udpNetwork, addr)
+		return c, pinger.ModeUnprivileged, err
+	}
+	return c, pinger.ModePrivileged, err
+}
+
+// pingServer reads inbound echo replies off a single socket (one per
+// address family) and hands each one to the Pinger that owns its ICMP ID.
+// It returns once ctx is done; a read deadline is kept refreshed so the
+// blocking ReadFrom call notices promptly instead of only on the next
+// inbound packet.
+func pingServer(ctx context.Context, wg *sync.WaitGroup, c *icmp.PacketConn, fam family, pingers map[pClientKey]*pinger.Pinger, l Logger) {
+	defer wg.Done()
+	proto := protoICMP
+	if fam == v6 {
+		proto = protoICMPv6
+	}
 	b := make([]byte, 65507)
 	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		c.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
 		count, _, err := c.ReadFrom(b)
 		if err != nil {
-			recordEvent(fmt.Sprintf("pingServer failed to read icmp packet: %v", err), logFile)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			l.Warnf("pingServer failed to read icmp packet: %v", err)
 			continue
 		}
 
-		// log.Printf("pingServer received %d bytes from ip: %s ", count, packetAddr.String())
-		m, err := icmp.ParseMessage(1, b[:count])
+		m, err := icmp.ParseMessage(proto, b[:count])
 		if err != nil {
-			recordEvent(fmt.Sprintf("pingServer failed to parse icmp packet: %v", err), logFile)
+			l.Warnf("pingServer failed to parse icmp packet: %v", err)
 			continue
 		}
-		switch b := m.Body.(type) {
+		switch echo := m.Body.(type) {
 		case *icmp.Echo:
-			if _, ok := clients[b.ID]; ok {
-				// log.Printf("Sending to client ID: %d channel: %v\n", b.ID, clients[b.ID].control)
-				clients[b.ID].control <- pingPacket{
-					ID:  b.ID,
-					Seq: b.Seq,
-				}
+			// Prefer the cookie carried in the payload: an unprivileged
+			// (SOCK_DGRAM) socket has its Echo ID rewritten by the kernel,
+			// so echo.ID can no longer be trusted to identify the Pinger.
+			id := echo.ID
+			if cookie, ok := pinger.ExtractID(echo.Data); ok {
+				id = int(cookie)
+			}
+			key := pClientKey{Family: fam, ID: id}
+			if p, ok := pingers[key]; ok {
+				p.Deliver(echo.Seq, echo.Data)
 			}
 		}
 	}
 }
 
-func pingClient(c *icmp.PacketConn, clientID int, client pClient, logFile *os.File) {
-	processSeq := 1
+// pingClient is a thin consumer of a Pinger's Results channel: it logs an
+// outage once Threshold consecutive probes have failed and its restore on
+// the first successful probe after that, and (if m is non-nil) feeds the same stream into
+// the Prometheus collectors. It returns once ctx is done, stopping the
+// Pinger's send loop with it.
+func pingClient(ctx context.Context, wg *sync.WaitGroup, p *pinger.Pinger, target monitoredTarget, l Logger, m *Metrics) {
+	defer wg.Done()
+	outage := false
+	fails := 0
+	var outageStart time.Time
+	p.Run(ctx)
 	for {
-		wm := icmp.Message{
-			Type: ipv4.ICMPTypeEcho,
-			Code: 8,
-			Body: &icmp.Echo{
-				ID:   clientID,
-				Seq:  processSeq,
-				Data: []byte("12345677890"),
-			},
-		}
-		wb, err := wm.Marshal(nil)
-		if err != nil {
-			recordEvent(fmt.Sprintf("pingClient: failed to marshal icmp packet to: %s with: %v", client.ip, err), logFile)
-		}
-		_, err = c.WriteTo(wb, &net.IPAddr{IP: net.ParseIP(client.ip)})
-		if err != nil {
-			recordEvent(fmt.Sprintf("pingClient: failed to send a packet to: %s %v", client.ip, err), logFile)
-		}
 		select {
-		case p := <-client.control:
-			if p.ID == clientID {
-				if client.outage {
-					recordEvent(fmt.Sprintf("pingClient: Connectivity outage cleared for: %s", client.ip), logFile)
+		case <-ctx.Done():
+			return
+		case r, ok := <-p.Results():
+			if !ok {
+				return
+			}
+			if m != nil {
+				m.sent.WithLabelValues(target.Address).Inc()
+			}
+			if r.Err != nil {
+				fails++
+				if fails >= target.Threshold && !outage {
+					outage = true
+					outageStart = time.Now()
+					if m != nil {
+						m.outage.WithLabelValues(target.Address).Set(1)
+					}
+					l.Event(target.GroupName, target.HostName, target.Address, "outage_detected", int(r.Seq), 0)
 				}
-				client.outage = false
+				continue
+			}
+			if m != nil {
+				m.received.WithLabelValues(target.Address).Inc()
+				m.rtt.WithLabelValues(target.Address).Observe(r.RTT.Seconds())
 			}
-		case <-time.After(1900 * time.Millisecond):
-			if !client.outage {
-				recordEvent(fmt.Sprintf("pingClient: Connectivity outage detected for: %s", client.ip), logFile)
+			fails = 0
+			if outage {
+				if m != nil {
+					m.outage.WithLabelValues(target.Address).Set(0)
+					m.outageDuration.WithLabelValues(target.Address).Add(time.Since(outageStart).Seconds())
+				}
+				l.Event(target.GroupName, target.HostName, target.Address, "outage_cleared", int(r.Seq), r.RTT)
 			}
-			client.outage = true
+			outage = false
 		}
-		processSeq++
-		time.Sleep(900 * time.Millisecond)
 	}
 }
 
+// main delegates to run so deferred cleanup (log file, sockets, context
+// cancellation) always executes before the process exits, regardless of
+// which exit code run reports.
 func main() {
+	os.Exit(run())
+}
+
+// run contains the program's actual logic. It returns the process exit
+// code instead of calling os.Exit directly, so its defers always run.
+func run() int {
 
-	listIPs := flag.String("ip", "", "comma seprated list of ip addresses to monitor.")
+	listIPs := flag.String("ip", "", "comma seprated list of ipv4/ipv6 addresses, CIDR blocks (10.0.0.0/24) or ranges (10.0.0.5-10.0.0.40) to monitor.")
+	configPath := flag.String("config", "", "Path to a config file defining monitored host groups.")
 	logLocation := flag.String("log", "/var/log/", "Location of the log file.")
+	unprivileged := flag.Bool("unprivileged", false, "Use an unprivileged (SOCK_DGRAM) ICMP socket instead of a raw socket. Falls back to this automatically if opening a raw socket fails with a permission error.")
+	maxTargets := flag.Int("max-targets", 4096, "Maximum number of targets a CIDR block or range in --ip may expand to.")
+	sweep := flag.Bool("sweep", false, "Perform a one-shot reachability sweep of the --ip targets, printing RTT for each, then exit.")
+	logFormat := flag.String("log-format", "text", "Event log format: text or json.")
+	metricsAddr := flag.String("metrics-addr", "", "If set, serve Prometheus metrics on this address, ex: :9101.")
 	help := flag.Bool("help", false, "Prints usage.")
 	version := flag.Bool("ver", false, "Prints the program's version")
 
@@ -169,79 +315,149 @@ func main() {
 
 	if *help {
 		fmt.Printf("%s", usage())
-		os.Exit(0)
+		return 0
 	}
 	if *version {
 		fmt.Printf("\nVersion: %s\n\n", programVersion)
-		os.Exit(0)
+		return 0
 	}
 	if len(flag.Args()) != 0 {
 		fmt.Printf("\nUnknown parameter %s see usage below, terinating.\n", flag.Args())
 		fmt.Printf("%s", usage())
-		os.Exit(1)
+		return 1
 	}
 	if len(os.Args) < 2 {
-		log.Fatalf("%s missing remote ip address(es) for ping, terminating...", os.Args[0])
-		os.Exit(1)
+		log.Printf("%s missing remote ip address(es) for ping, terminating...", os.Args[0])
+		return 1
 	}
 
-	// Parse and validate the list of IPs passed as argument(s)
-	pingIPs, err := parseIPs(*listIPs)
-	if err != nil {
-		log.Fatalf("%s failed: %v, terminating...", os.Args[0], err)
-		os.Exit(1)
+	// Build the list of targets, either from --config or the flat --ip list.
+	var targets []monitoredTarget
+	if *configPath != "" {
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			log.Printf("%s failed to load config %s: %v, terminating...", os.Args[0], *configPath, err)
+			return 1
+		}
+		targets = targetsFromConfig(cfg)
+	} else {
+		var truncated bool
+		var err error
+		targets, truncated, err = parseIPs(*listIPs, *maxTargets)
+		if err != nil {
+			log.Printf("%s failed: %v, terminating...", os.Args[0], err)
+			return 1
+		}
+		if truncated {
+			log.Printf("%s: --ip expanded to --max-targets (%d) entries and was truncated; raise --max-targets to scan more\n", os.Args[0], *maxTargets)
+		}
 	}
 
 	// Start logging
 	logFile := startLogging(*logLocation)
 	defer logFile.Close()
 
-	// Build pingClientsList
-	pingClientList := map[int]pClient{}
-	for id, ip := range pingIPs {
-		pingClientList[id+1] = pClient{
-			control: make(chan pingPacket),
-			ID:      id + 1,
-			ip:      ip,
-			outage:  false,
-		}
+	var l Logger
+	if *logFormat == "json" {
+		l = newJSONLogger(logFile)
+	} else {
+		l = newTextLogger(logFile)
 	}
 
-	// Open connection for listen all incoming icmp packets
-	connection, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
-	if err != nil {
-		recordEvent(fmt.Sprintf("%s failed to listen for icmp packets with: %v, terminating", os.Args[0], err), logFile)
-		os.Exit(1)
+	if *sweep {
+		return runSweep(targets, *unprivileged, l)
+	}
+
+	var metrics *Metrics
+	if *metricsAddr != "" {
+		metrics = newMetrics()
+		go metrics.serve(*metricsAddr, l)
 	}
-	defer connection.Close()
 
 	// Capture signals to close the log file before exiting
-	c := make(chan os.Signal, 1)
-	signal.Notify(c,
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh,
 		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT)
-	go func() {
-		for sig := range c {
-			recordEvent(fmt.Sprintf("Captured %v, closing log and terminating", sig), logFile)
-			connection.Close()
-			logFile.Close()
-			os.Exit(0)
+
+	haveV4, haveV6 := false, false
+	for _, t := range targets {
+		if familyOf(t.Address) == v4 {
+			haveV4 = true
+		} else {
+			haveV6 = true
 		}
+	}
+
+	var err error
+	var connV4, connV6 *icmp.PacketConn
+	var modeV4, modeV6 pinger.Mode
+	if haveV4 {
+		connV4, modeV4, err = listenICMP(v4, *unprivileged, l)
+		if err != nil {
+			l.Warnf("%s failed to listen for icmpv4 packets with: %v, terminating", os.Args[0], err)
+			return 1
+		}
+		defer connV4.Close()
+	}
+	if haveV6 {
+		connV6, modeV6, err = listenICMP(v6, *unprivileged, l)
+		if err != nil {
+			l.Warnf("%s failed to listen for icmpv6 packets with: %v, terminating", os.Args[0], err)
+			return 1
+		}
+		defer connV6.Close()
+	}
+
+	// Build a Pinger per target, keyed so pingServer can route replies back
+	// to the right one regardless of address family.
+	pingers := map[pClientKey]*pinger.Pinger{}
+	clients := make([]clientEntry, 0, len(targets))
+	for _, t := range targets {
+		fam := familyOf(t.Address)
+		conn, pFam, mode := connV4, pinger.V4, modeV4
+		if fam == v6 {
+			conn, pFam, mode = connV6, pinger.V6, modeV6
+		}
+		p := newPinger(t, fam, conn, pFam, mode, pingers)
+		clients = append(clients, clientEntry{pinger: p, target: t})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var shutdownBySignal int32
+	go func() {
+		sig := <-sigCh
+		l.Infof("Captured %v, closing log and terminating", sig)
+		atomic.StoreInt32(&shutdownBySignal, 1)
+		cancel()
 	}()
 
-	recordEvent(fmt.Sprintf("Starting pingServer and pingClient"), logFile)
+	l.Infof("Starting pingServer and pingClient")
 
-	// Starting pingServer and passing list of all ping clients with their
-	// corresponding information
-	go pingServer(connection, pingClientList, logFile)
+	var wg sync.WaitGroup
 
-	// Adding wait groups just for main to wait on something other than dead loop
-	// this programm does not have a way to terminate other than kill.
-	wg.Add(len(pingClientList))
-	for id := range pingClientList {
-		go pingClient(connection, id, pingClientList[id], logFile)
+	// Starting pingServer once per socket, sharing the same pinger map since
+	// pClientKey already disambiguates v4 from v6 entries.
+	if connV4 != nil {
+		wg.Add(1)
+		go pingServer(ctx, &wg, connV4, v4, pingers, l)
+	}
+	if connV6 != nil {
+		wg.Add(1)
+		go pingServer(ctx, &wg, connV6, v6, pingers, l)
+	}
+
+	wg.Add(len(clients))
+	for _, cl := range clients {
+		go pingClient(ctx, &wg, cl.pinger, cl.target, l, metrics)
 	}
 	wg.Wait()
+
+	if atomic.LoadInt32(&shutdownBySignal) != 0 {
+		return 1
+	}
+	return 0
 }
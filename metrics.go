@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the Prometheus collectors fed by the Pinger event stream,
+// so they stay consistent with whatever the configured Logger records.
+type Metrics struct {
+	rtt            *prometheus.HistogramVec
+	sent           *prometheus.CounterVec
+	received       *prometheus.CounterVec
+	outage         *prometheus.GaugeVec
+	outageDuration *prometheus.CounterVec
+}
+
+func newMetrics() *Metrics {
+	m := &Metrics{
+		rtt: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "bgping_rtt_seconds",
+			Help:    "Round-trip time of successful ICMP echo probes.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"target"}),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bgping_packets_sent_total",
+			Help: "Total number of ICMP echo requests sent.",
+		}, []string{"target"}),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bgping_packets_received_total",
+			Help: "Total number of ICMP echo replies received.",
+		}, []string{"target"}),
+		outage: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "bgping_outage",
+			Help: "1 if the target is currently considered unreachable, 0 otherwise.",
+		}, []string{"target"}),
+		outageDuration: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bgping_outage_duration_seconds_total",
+			Help: "Cumulative time spent with the target in an outage state.",
+		}, []string{"target"}),
+	}
+	prometheus.MustRegister(m.rtt, m.sent, m.received, m.outage, m.outageDuration)
+	return m
+}
+
+// serve starts the Prometheus HTTP endpoint and blocks; call it in its own
+// goroutine.
+func (m *Metrics) serve(addr string, l Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	l.Infof("Serving Prometheus metrics on %s/metrics", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		l.Warnf("metrics server stopped: %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+// Package pinger implements a small concurrent ICMP echo-request manager.
+//
+// Each Pinger owns a single target and a single 16-bit ICMP ID. Callers are
+// responsible for demultiplexing inbound echo replies by ID (there is
+// usually one shared icmp.PacketConn per address family) and handing the
+// sequence number and payload of a matching reply to Deliver.
+package pinger
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Family identifies the IP version a Pinger talks over.
+type Family int
+
+const (
+	V4 Family = 4
+	V6 Family = 6
+)
+
+// Mode selects the kind of socket a Pinger writes to. Unprivileged sockets
+// (SOCK_DGRAM "udp4"/"udp6") have their ICMP ID rewritten by the kernel, so
+// replies must be demultiplexed via the cookie embedded in the payload
+// instead of the Echo ID.
+type Mode int
+
+const (
+	ModePrivileged Mode = iota
+	ModeUnprivileged
+)
+
+// cookieLen is the size, in bytes, of the ID cookie appended after the
+// timestamp in every echo payload.
+const cookieLen = 2
+
+// Result is delivered on a Pinger's Results channel for every probe that is
+// either answered or timed out.
+type Result struct {
+	IP  string
+	Seq uint16
+	RTT time.Duration
+	Err error
+}
+
+type outstanding struct {
+	sentAt time.Time
+}
+
+// Pinger sends periodic ICMP echo requests to a single target and reports
+// RTT (or timeout) on its Results channel.
+type Pinger struct {
+	ID       uint16
+	Target   string
+	Family   Family
+	Mode     Mode
+	Interval time.Duration
+	Timeout  time.Duration
+
+	conn *icmp.PacketConn
+
+	mu      sync.Mutex
+	pending map[uint16]outstanding
+	seq     uint16
+
+	results chan Result
+}
+
+// RandomID returns a random 16-bit ICMP ID. Exported so callers managing
+// many Pingers (e.g. a subnet sweep) can reroll on collision, since IDs are
+// only unique within a single address family.
+func RandomID() uint16 {
+	return uint16(rand.Intn(1 << 16))
+}
+
+// New creates a Pinger for target, sending over conn. conn is shared across
+// every Pinger of the same address family; the caller owns its lifetime.
+func New(conn *icmp.PacketConn, target string, fam Family) *Pinger {
+	return &Pinger{
+		ID:       RandomID(),
+		Target:   target,
+		Family:   fam,
+		Interval: 900 * time.Millisecond,
+		Timeout:  1900 * time.Millisecond,
+		conn:     conn,
+		pending:  make(map[uint16]outstanding),
+		results:  make(chan Result, 16),
+	}
+}
+
+// Results returns the channel Result values are delivered on.
+func (p *Pinger) Results() <-chan Result {
+	return p.results
+}
+
+// emit delivers r on the Results channel without blocking the send/reap
+// loop; a Result is dropped if the caller isn't draining Results quickly
+// enough to keep up with the buffer.
+func (p *Pinger) emit(r Result) {
+	select {
+	case p.results <- r:
+	default:
+	}
+}
+
+// Run starts the send loop in its own goroutine. The loop exits once ctx is
+// done, so callers (including tests) can stop a Pinger deterministically
+// without leaking the goroutine.
+func (p *Pinger) Run(ctx context.Context) {
+	go p.run(ctx)
+}
+
+func (p *Pinger) run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		p.send()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		p.reap()
+	}
+}
+
+// Probe sends a single echo request and blocks for at most timeout waiting
+// for its reply, returning the Result that it reads back off the Results
+// channel. Intended for one-shot uses (e.g. a sweep) where
+// there is no Run loop; the caller must still be feeding inbound replies to
+// Deliver concurrently.
+func (p *Pinger) Probe(timeout time.Duration) Result {
+	p.send()
+	select {
+	case r := <-p.results:
+		return r
+	case <-time.After(timeout):
+		p.mu.Lock()
+		for seq := range p.pending {
+			delete(p.pending, seq)
+		}
+		p.mu.Unlock()
+		return Result{IP: p.Target, Err: fmt.Errorf("timeout waiting for echo reply")}
+	}
+}
+
+// reap emits a timeout Result for every outstanding probe older than Timeout.
+func (p *Pinger) reap() {
+	now := time.Now()
+	p.mu.Lock()
+	for seq, o := range p.pending {
+		if now.Sub(o.sentAt) > p.Timeout {
+			delete(p.pending, seq)
+			p.emit(Result{IP: p.Target, Seq: seq, Err: fmt.Errorf("timeout waiting for echo reply")})
+		}
+	}
+	p.mu.Unlock()
+}
+
+func (p *Pinger) send() {
+	p.mu.Lock()
+	p.seq++
+	seq := p.seq
+	p.pending[seq] = outstanding{sentAt: time.Now()}
+	p.mu.Unlock()
+
+	data := make([]byte, 8+cookieLen)
+	binary.BigEndian.PutUint64(data, uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint16(data[8:], p.ID)
+
+	var echoType icmp.Type = ipv4.ICMPTypeEcho
+	if p.Family == V6 {
+		echoType = ipv6.ICMPTypeEchoRequest
+	}
+	wm := icmp.Message{
+		Type: echoType,
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:   int(p.ID),
+			Seq:  int(seq),
+			Data: data,
+		},
+	}
+	wb, err := wm.Marshal(nil)
+	if err != nil {
+		p.emit(Result{IP: p.Target, Seq: seq, Err: err})
+		return
+	}
+	if _, err := p.conn.WriteTo(wb, p.dstAddr()); err != nil {
+		p.emit(Result{IP: p.Target, Seq: seq, Err: err})
+	}
+}
+
+// dstAddr builds the destination address in the form expected by the
+// underlying socket type: raw ICMP sockets take an *net.IPAddr, while
+// unprivileged (SOCK_DGRAM) sockets take an *net.UDPAddr.
+func (p *Pinger) dstAddr() net.Addr {
+	ip := net.ParseIP(p.Target)
+	if p.Mode == ModeUnprivileged {
+		return &net.UDPAddr{IP: ip}
+	}
+	return &net.IPAddr{IP: ip}
+}
+
+// Deliver hands an inbound echo reply (already matched to this Pinger's ID
+// by the caller) off for RTT accounting.
+func (p *Pinger) Deliver(seq int, payload []byte) {
+	now := time.Now()
+	s := uint16(seq)
+
+	p.mu.Lock()
+	o, ok := p.pending[s]
+	if ok {
+		delete(p.pending, s)
+	}
+	p.mu.Unlock()
+	if !ok {
+		// Late or duplicate reply for a probe we already reaped.
+		return
+	}
+
+	p.emit(Result{IP: p.Target, Seq: s, RTT: decodeRTT(payload, now, o.sentAt)})
+}
+
+// ExtractID recovers the Pinger ID cookie embedded in an echo payload by
+// send. Callers demultiplexing replies from an unprivileged socket (whose
+// kernel rewrites the Echo ID) should prefer this over the Echo ID field.
+func ExtractID(payload []byte) (uint16, bool) {
+	if len(payload) < 8+cookieLen {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(payload[8 : 8+cookieLen]), true
+}
+
+// decodeRTT recovers the RTT from the timestamp embedded in the echo
+// payload, falling back to the wall-clock delta since send if the payload
+// was mangled or truncated in transit.
+func decodeRTT(payload []byte, now, sentAt time.Time) time.Duration {
+	if len(payload) >= 8 {
+		if sent := int64(binary.BigEndian.Uint64(payload[:8])); sent > 0 {
+			return now.Sub(time.Unix(0, sent))
+		}
+	}
+	return now.Sub(sentAt)
+}
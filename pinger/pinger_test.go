@@ -0,0 +1,111 @@
+package pinger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+func TestDecodeRTT(t *testing.T) {
+	sentAt := time.Now().Add(-50 * time.Millisecond)
+	payload := make([]byte, 8)
+	putTimestamp(payload, sentAt)
+
+	rtt := decodeRTT(payload, time.Now(), sentAt)
+	if rtt < 40*time.Millisecond || rtt > 200*time.Millisecond {
+		t.Fatalf("decodeRTT = %s, want roughly 50ms", rtt)
+	}
+}
+
+func TestDecodeRTTFallsBackOnShortPayload(t *testing.T) {
+	sentAt := time.Now().Add(-10 * time.Millisecond)
+	rtt := decodeRTT(nil, time.Now(), sentAt)
+	if rtt <= 0 {
+		t.Fatalf("decodeRTT with short payload = %s, want a positive fallback RTT", rtt)
+	}
+}
+
+func TestDeliverComputesRTT(t *testing.T) {
+	p := &Pinger{
+		Target:  "192.0.2.1",
+		pending: map[uint16]outstanding{7: {sentAt: time.Now().Add(-20 * time.Millisecond)}},
+		results: make(chan Result, 1),
+	}
+	payload := make([]byte, 8)
+	putTimestamp(payload, time.Now().Add(-20*time.Millisecond))
+
+	p.Deliver(7, payload)
+
+	select {
+	case r := <-p.Results():
+		if r.Seq != 7 || r.IP != "192.0.2.1" || r.Err != nil || r.RTT < 20*time.Millisecond || r.RTT > 200*time.Millisecond {
+			t.Fatalf("unexpected result: %+v", r)
+		}
+	default:
+		t.Fatal("Deliver did not emit a Result")
+	}
+}
+
+func TestDeliverIgnoresUnknownSeq(t *testing.T) {
+	p := &Pinger{
+		Target:  "192.0.2.1",
+		pending: map[uint16]outstanding{},
+		results: make(chan Result, 1),
+	}
+	p.Deliver(99, make([]byte, 8))
+
+	select {
+	case r := <-p.Results():
+		t.Fatalf("Deliver emitted a Result for an unknown seq: %+v", r)
+	default:
+	}
+}
+
+func TestEmitDoesNotBlockWhenFull(t *testing.T) {
+	p := &Pinger{results: make(chan Result, 1)}
+	p.emit(Result{Seq: 1})
+	p.emit(Result{Seq: 2}) // must not block even though the buffer is full
+
+	r := <-p.results
+	if r.Seq != 1 {
+		t.Fatalf("got Seq %d, want the first emitted Result to survive", r.Seq)
+	}
+}
+
+func TestRunStopsOnContextCancel(t *testing.T) {
+	conn, err := icmp.ListenPacket("udp4", "127.0.0.1")
+	if err != nil {
+		t.Skipf("unprivileged ICMP socket unavailable in this environment: %v", err)
+	}
+	defer conn.Close()
+
+	p := New(conn, "127.0.0.1", V4)
+	p.Mode = ModeUnprivileged
+	p.Interval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("run did not return after its context was canceled")
+	}
+}
+
+func putTimestamp(payload []byte, t time.Time) {
+	ts := uint64(t.UnixNano())
+	for i := 7; i >= 0; i-- {
+		payload[i] = byte(ts)
+		ts >>= 8
+	}
+}
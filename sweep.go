@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/sbezverk/bg-ping/pinger"
+
+	"golang.org/x/net/icmp"
+)
+
+// runSweep performs a single reachability probe against every target,
+// printing its RTT (or the reason it didn't respond) to stdout. It returns
+// the number of unreachable targets, suitable for use as main's exit code.
+func runSweep(targets []monitoredTarget, unprivileged bool, l Logger) int {
+	haveV4, haveV6 := false, false
+	for _, t := range targets {
+		if familyOf(t.Address) == v4 {
+			haveV4 = true
+		} else {
+			haveV6 = true
+		}
+	}
+
+	var err error
+	var connV4, connV6 *icmp.PacketConn
+	var modeV4, modeV6 pinger.Mode
+	if haveV4 {
+		connV4, modeV4, err = listenICMP(v4, unprivileged, l)
+		if err != nil {
+			fmt.Printf("failed to open an icmpv4 socket: %v\n", err)
+			return len(targets)
+		}
+		defer connV4.Close()
+	}
+	if haveV6 {
+		connV6, modeV6, err = listenICMP(v6, unprivileged, l)
+		if err != nil {
+			fmt.Printf("failed to open an icmpv6 socket: %v\n", err)
+			return len(targets)
+		}
+		defer connV6.Close()
+	}
+
+	pingers := map[pClientKey]*pinger.Pinger{}
+	clients := make([]clientEntry, 0, len(targets))
+	for _, t := range targets {
+		fam := familyOf(t.Address)
+		conn, pFam, mode := connV4, pinger.V4, modeV4
+		if fam == v6 {
+			conn, pFam, mode = connV6, pinger.V6, modeV6
+		}
+		p := newPinger(t, fam, conn, pFam, mode, pingers)
+		clients = append(clients, clientEntry{pinger: p, target: t})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var serverWG sync.WaitGroup
+	if connV4 != nil {
+		serverWG.Add(1)
+		go pingServer(ctx, &serverWG, connV4, v4, pingers, l)
+	}
+	if connV6 != nil {
+		serverWG.Add(1)
+		go pingServer(ctx, &serverWG, connV6, v6, pingers, l)
+	}
+
+	var printMu sync.Mutex
+	unreachable := 0
+	var probeWG sync.WaitGroup
+	probeWG.Add(len(clients))
+	for _, cl := range clients {
+		go func(cl clientEntry) {
+			defer probeWG.Done()
+			r := cl.pinger.Probe(cl.target.Timeout)
+			printMu.Lock()
+			defer printMu.Unlock()
+			if r.Err != nil {
+				fmt.Printf("%-40s unreachable: %v\n", cl.target.Address, r.Err)
+				unreachable++
+				return
+			}
+			fmt.Printf("%-40s reachable rtt=%s\n", cl.target.Address, r.RTT)
+		}(cl)
+	}
+	probeWG.Wait()
+
+	cancel()
+	serverWG.Wait()
+
+	return unreachable
+}
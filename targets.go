@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// expandEntry resolves a single --ip entry (a bare address, a CIDR block
+// such as 10.0.0.0/24, or a dashed range such as 10.0.0.5-10.0.0.40) into
+// the individual addresses it covers. budget caps how many addresses are
+// returned; if the entry would exceed it, the result is truncated and
+// truncated is reported true.
+func expandEntry(entry string, budget int) (addrs []string, truncated bool, err error) {
+	switch {
+	case strings.Contains(entry, "/"):
+		return expandCIDR(entry, budget)
+	case strings.Contains(entry, "-"):
+		return expandRange(entry, budget)
+	default:
+		if net.ParseIP(entry) == nil {
+			return nil, false, fmt.Errorf(" %s is an invalid ip address", entry)
+		}
+		if budget <= 0 {
+			return nil, true, nil
+		}
+		return []string{entry}, false, nil
+	}
+}
+
+// expandCIDR enumerates every host address in a CIDR block, dropping the
+// network and broadcast addresses for IPv4 blocks wider than a /31.
+func expandCIDR(cidr string, budget int) ([]string, bool, error) {
+	ip, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s is not a valid CIDR block: %v", cidr, err)
+	}
+	isV4 := ip.To4() != nil
+
+	var addrs []string
+	truncated := false
+	cur := cloneIP(ipnet.IP.Mask(ipnet.Mask))
+	var broadcast net.IP
+	if ones, _ := ipnet.Mask.Size(); isV4 && ones < 31 {
+		broadcast = make(net.IP, len(cur))
+		for i := range cur {
+			broadcast[i] = cur[i] | ^ipnet.Mask[i]
+		}
+		incIP(cur)
+	}
+	for ipnet.Contains(cur) && !cur.Equal(broadcast) {
+		if len(addrs) >= budget {
+			truncated = true
+			break
+		}
+		addrs = append(addrs, cur.String())
+		incIP(cur)
+	}
+	return addrs, truncated, nil
+}
+
+// expandRange enumerates every address between the two (inclusive) ends of
+// a dashed range, e.g. "10.0.0.5-10.0.0.40".
+func expandRange(spec string, budget int) ([]string, bool, error) {
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return nil, false, fmt.Errorf("%s is not a valid ip range", spec)
+	}
+	start := net.ParseIP(strings.TrimSpace(parts[0]))
+	end := net.ParseIP(strings.TrimSpace(parts[1]))
+	if start == nil || end == nil {
+		return nil, false, fmt.Errorf("%s is not a valid ip range", spec)
+	}
+	sb, eb := normalizeIP(start), normalizeIP(end)
+	if len(sb) != len(eb) {
+		return nil, false, fmt.Errorf("%s mixes IPv4 and IPv6 addresses", spec)
+	}
+	if bytes.Compare(sb, eb) > 0 {
+		return nil, false, fmt.Errorf("%s: range start is after its end", spec)
+	}
+
+	var addrs []string
+	truncated := false
+	for cur := cloneIP(sb); ; incIP(cur) {
+		if len(addrs) >= budget {
+			truncated = true
+			break
+		}
+		addrs = append(addrs, cur.String())
+		if bytes.Equal(cur, eb) {
+			break
+		}
+	}
+	return addrs, truncated, nil
+}
+
+// normalizeIP returns the 4-byte form of an IPv4 address and the 16-byte
+// form otherwise, so two addresses of the same family compare and
+// increment consistently.
+func normalizeIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip.To16()
+}
+
+func cloneIP(ip net.IP) net.IP {
+	n := normalizeIP(ip)
+	out := make(net.IP, len(n))
+	copy(out, n)
+	return out
+}
+
+// incIP increments ip in place, treating it as a big-endian counter.
+func incIP(ip net.IP) {
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import "testing"
+
+func TestExpandEntrySingleAddress(t *testing.T) {
+	addrs, truncated, err := expandEntry("10.0.0.1", 10)
+	if err != nil || truncated || len(addrs) != 1 || addrs[0] != "10.0.0.1" {
+		t.Fatalf("expandEntry(10.0.0.1) = %v, %v, %v", addrs, truncated, err)
+	}
+}
+
+func TestExpandEntryInvalidAddress(t *testing.T) {
+	if _, _, err := expandEntry("not-an-ip", 10); err == nil {
+		t.Fatal("expected an error for an invalid address")
+	}
+}
+
+func TestExpandCIDRExcludesNetworkAndBroadcast(t *testing.T) {
+	addrs, truncated, err := expandEntry("10.0.0.0/30", 10)
+	if err != nil {
+		t.Fatalf("expandEntry: %v", err)
+	}
+	if truncated {
+		t.Fatal("did not expect truncation")
+	}
+	want := []string{"10.0.0.1", "10.0.0.2"}
+	if len(addrs) != len(want) {
+		t.Fatalf("addrs = %v, want %v", addrs, want)
+	}
+	for i, a := range addrs {
+		if a != want[i] {
+			t.Fatalf("addrs = %v, want %v", addrs, want)
+		}
+	}
+}
+
+func TestExpandCIDRTruncatesAtBudget(t *testing.T) {
+	addrs, truncated, err := expandEntry("10.0.0.0/24", 2)
+	if err != nil {
+		t.Fatalf("expandEntry: %v", err)
+	}
+	if !truncated {
+		t.Fatal("expected truncation to be reported")
+	}
+	if len(addrs) != 2 {
+		t.Fatalf("len(addrs) = %d, want 2", len(addrs))
+	}
+}
+
+func TestExpandRange(t *testing.T) {
+	addrs, truncated, err := expandEntry("10.0.0.5-10.0.0.8", 10)
+	if err != nil {
+		t.Fatalf("expandEntry: %v", err)
+	}
+	if truncated {
+		t.Fatal("did not expect truncation")
+	}
+	want := []string{"10.0.0.5", "10.0.0.6", "10.0.0.7", "10.0.0.8"}
+	if len(addrs) != len(want) {
+		t.Fatalf("addrs = %v, want %v", addrs, want)
+	}
+	for i, a := range addrs {
+		if a != want[i] {
+			t.Fatalf("addrs = %v, want %v", addrs, want)
+		}
+	}
+}
+
+func TestExpandRangeRejectsReversedBounds(t *testing.T) {
+	if _, _, err := expandEntry("10.0.0.8-10.0.0.5", 10); err == nil {
+		t.Fatal("expected an error when the range start is after its end")
+	}
+}
+
+func TestExpandRangeRejectsMixedFamilies(t *testing.T) {
+	if _, _, err := expandEntry("10.0.0.1-::1", 10); err == nil {
+		t.Fatal("expected an error when mixing IPv4 and IPv6 in a range")
+	}
+}